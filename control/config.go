@@ -0,0 +1,62 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import "time"
+
+// Config holds the options shared by a benchmark run and its report.
+type Config struct {
+	// ResultPathTimeSeries is where the per-second sample series is saved.
+	ResultPathTimeSeries string
+
+	// LiveReport toggles the refreshing terminal dashboard while a run
+	// is still in progress.
+	LiveReport bool
+	// LiveReportInterval is how often the dashboard refreshes; defaults
+	// to defaultLiveReportInterval when zero.
+	LiveReportInterval time.Duration
+
+	// MetricsAddr, if set, serves Prometheus metrics at /metrics on this
+	// address for the duration of the run.
+	MetricsAddr string
+	// PushgatewayAddr, if set, pushes the final metrics to this
+	// Prometheus Pushgateway once the run completes.
+	PushgatewayAddr string
+
+	// HDRLowestTrackable, HDRHighestTrackable and HDRSignificantFigures
+	// override the latency recorder's default range and precision when
+	// set.
+	HDRLowestTrackable    int64
+	HDRHighestTrackable   int64
+	HDRSignificantFigures int
+	// ExpectedInterval, if set, enables coordinated-omission correction
+	// at this inter-request interval.
+	ExpectedInterval time.Duration
+
+	// ResultPathHDRIntervalLog is where the dbtester-specific HDR interval
+	// log is saved (not the genuine HdrHistogram V2 encoding; see
+	// latencyRecorder.WriteIntervalLog).
+	ResultPathHDRIntervalLog string
+	// ResultPathHDRSummary is where the HDR JSON summary is saved.
+	ResultPathHDRSummary string
+
+	// ResultFormats selects the sinks a run's results are written to
+	// ("text", "csv", "ndjson", "json"); defaults to just "text" when
+	// empty.
+	ResultFormats []string
+	// ResultPaths maps a format in ResultFormats to the file it writes
+	// to; unused by the "text" format, which prints to stdout.
+	ResultPaths map[string]string
+}
@@ -0,0 +1,51 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyRecorderClampsOutOfRangeValues(t *testing.T) {
+	l, err := newLatencyRecorder(1, int64(time.Second/time.Microsecond), 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		l.Record(10 * time.Millisecond)
+	}
+	l.Record(90 * time.Second)
+
+	if got := l.hist.TotalCount(); got != 101 {
+		t.Fatalf("TotalCount() = %d, want 101 (outlier should be clamped, not dropped)", got)
+	}
+}
+
+// hdrhistogram.New panics outside [1, 5] significant figures; callers
+// must get a clean error instead.
+func TestNewLatencyRecorderRejectsInvalidSignificantFigures(t *testing.T) {
+	for _, sigfigs := range []int{0, -1, 6} {
+		if _, err := newLatencyRecorder(1, int64(time.Second/time.Microsecond), sigfigs, 0); err == nil {
+			t.Errorf("newLatencyRecorder with sigfigs=%d: want error, got nil", sigfigs)
+		}
+	}
+}
+
+func TestNewDefaultLatencyRecorderRejectsInvalidSignificantFigures(t *testing.T) {
+	if _, err := newDefaultLatencyRecorder(Config{HDRSignificantFigures: 6}); err == nil {
+		t.Error("newDefaultLatencyRecorder with HDRSignificantFigures=6: want error, got nil")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// benchMetrics mirrors the fields report tracks as Prometheus collectors.
+type benchMetrics struct {
+	registry *prometheus.Registry
+
+	latency       prometheus.Histogram
+	requestsTotal prometheus.Counter
+	statusTotal   *prometheus.CounterVec
+	errorsTotal   *prometheus.CounterVec
+	rps           prometheus.Gauge
+
+	// srv is the /metrics listener started by serve; kept so shutdown can
+	// stop it once the run no longer needs it, freeing MetricsAddr for
+	// reuse (by a later run, or a test).
+	srv *http.Server
+}
+
+// newBenchMetrics builds the collector set and registers it on a
+// dedicated registry.
+func newBenchMetrics() *benchMetrics {
+	m := &benchMetrics{
+		registry: prometheus.NewRegistry(),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:                            "dbtester_request_duration_seconds",
+			Help:                            "Request latency distribution, as a native exponential histogram so tail buckets stay accurate.",
+			NativeHistogramBucketFactor:     1.1,
+			NativeHistogramMaxBucketNumber:  160,
+			NativeHistogramMinResetDuration: time.Hour,
+		}),
+		requestsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dbtester_requests_total",
+			Help: "Total number of completed requests.",
+		}),
+		statusTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbtester_status_total",
+			Help: "Completed requests by status code class.",
+		}, []string{"class"}),
+		errorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dbtester_errors_total",
+			Help: "Completed requests by error string.",
+		}, []string{"error"}),
+		rps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dbtester_requests_per_second",
+			Help: "Current requests per second, sampled over the live report interval.",
+		}),
+	}
+	m.registry.MustRegister(m.latency, m.requestsTotal, m.statusTotal, m.errorsTotal, m.rps)
+	return m
+}
+
+// Observe folds one completed result into the collectors.
+func (m *benchMetrics) Observe(res result) {
+	m.requestsTotal.Inc()
+	m.statusTotal.WithLabelValues(statusClass(res.status)).Inc()
+	if res.errStr != "" {
+		m.errorsTotal.WithLabelValues(res.errStr).Inc()
+	} else {
+		m.latency.Observe(res.duration.Seconds())
+	}
+}
+
+// SetRPS updates the current-RPS gauge.
+func (m *benchMetrics) SetRPS(rps float64) {
+	m.rps.Set(rps)
+}
+
+// serve exposes the collectors on addr at /metrics and starts listening
+// in its own goroutine, returning a stop func that shuts the listener
+// down and frees addr for reuse. m.srv is assigned before serve returns,
+// so stop is always safe to call.
+func (m *benchMetrics) serve(addr string) (stop func(context.Context) error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	m.srv = &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		if err := m.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			plog.Warningf("metrics server on %s stopped: %v", addr, err)
+		}
+	}()
+
+	return m.srv.Shutdown
+}
+
+// sampleRPSForMetrics keeps the RPS gauge fresh when the live dashboard
+// is disabled, and returns a func that stops the sampling goroutine.
+func (r *report) sampleRPSForMetrics() (stop func()) {
+	donec := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(defaultLiveReportInterval)
+		defer ticker.Stop()
+		last, lastTime := int64(0), time.Now()
+		for {
+			select {
+			case <-ticker.C:
+				r.mu.Lock()
+				count := r.stats.Count()
+				r.mu.Unlock()
+				now := time.Now()
+				if elapsed := now.Sub(lastTime).Seconds(); elapsed > 0 {
+					r.metrics.SetRPS(float64(count-last) / elapsed)
+				}
+				last, lastTime = count, now
+			case <-donec:
+				return
+			}
+		}
+	}()
+	return func() { close(donec) }
+}
+
+// pushToGateway does a one-shot push of the current collector values to
+// a Prometheus Pushgateway.
+func (m *benchMetrics) pushToGateway(url string) error {
+	return push.New(url, "dbtester").Gatherer(m.registry).Push()
+}
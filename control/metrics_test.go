@@ -0,0 +1,105 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// freeAddr reserves a loopback port and immediately releases it, for
+// tests that need a real, unused address to bind to.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve a free port: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func waitForGet(t *testing.T, url string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		lastErr = err
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never became reachable: %v", url, lastErr)
+}
+
+// serve's stop func must actually free its address, so a second serve on
+// the same address (another run, or this test) can bind.
+func TestBenchMetricsServeStopFreesAddr(t *testing.T) {
+	addr := freeAddr(t)
+
+	m1 := newBenchMetrics()
+	stop1 := m1.serve(addr)
+	waitForGet(t, fmt.Sprintf("http://%s/metrics", addr))
+	if err := stop1(context.Background()); err != nil {
+		t.Fatalf("first serve's stop failed: %v", err)
+	}
+
+	m2 := newBenchMetrics()
+	stop2 := m2.serve(addr)
+	defer stop2(context.Background())
+	waitForGet(t, fmt.Sprintf("http://%s/metrics", addr))
+}
+
+// sampleRPSForMetrics must keep the RPS gauge moving off of the stats
+// count, for the LiveReport-disabled case where nothing else samples it.
+// It ticks on defaultLiveReportInterval (1s), so this test is slow but
+// exercises the real timing rather than a faked one.
+func TestSampleRPSForMetrics(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow RPS-sampling test in -short mode")
+	}
+
+	r := newTestReport(t, Config{})
+	r.metrics = newBenchMetrics()
+
+	stop := r.sampleRPSForMetrics()
+	defer stop()
+
+	r.mu.Lock()
+	r.stats.Add(0.1)
+	r.mu.Unlock()
+
+	deadline := time.After(3 * time.Second)
+	for {
+		if testutil.ToFloat64(r.metrics.rps) != 0 {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatal("sampleRPSForMetrics never updated the rps gauge within 3s")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"math"
+	"sort"
+)
+
+// quantileTarget is a tracked percentile and its allowed rank error.
+type quantileTarget struct {
+	quantile float64
+	epsilon  float64
+}
+
+// defaultQuantileTargets are the percentiles printReport surfaces, with
+// tighter epsilons towards the tail.
+var defaultQuantileTargets = []quantileTarget{
+	{0.10, 0.01},
+	{0.25, 0.01},
+	{0.50, 0.01},
+	{0.75, 0.01},
+	{0.90, 0.01},
+	{0.95, 0.001},
+	{0.99, 0.001},
+	{0.999, 0.0001},
+}
+
+// quantileSample is a retained observation with CKMS rank-error
+// bookkeeping: width is how many observations it represents, delta is
+// the max uncertainty in its rank.
+type quantileSample struct {
+	value float64
+	width float64
+	delta float64
+}
+
+// quantileStream computes targeted quantiles of a data stream in bounded
+// space (Cormode/Korkmaz-Muthukrishnan), without sorting the full
+// latency slice.
+type quantileStream struct {
+	targets []quantileTarget
+
+	samples []quantileSample
+	n       float64
+
+	buf    []float64
+	bufCap int
+}
+
+// newQuantileStream returns a quantileStream tracking the given targets.
+func newQuantileStream(targets []quantileTarget) *quantileStream {
+	return &quantileStream{
+		targets: targets,
+		bufCap:  500,
+	}
+}
+
+// Insert adds v, flushing the input buffer once it fills up.
+func (q *quantileStream) Insert(v float64) {
+	q.buf = append(q.buf, v)
+	if len(q.buf) >= q.bufCap {
+		q.flush()
+	}
+}
+
+// flush merges the pending buffer into q.samples, then compresses it.
+func (q *quantileStream) flush() {
+	if len(q.buf) == 0 {
+		return
+	}
+	sort.Float64s(q.buf)
+	q.merge(q.buf)
+	q.buf = q.buf[:0]
+}
+
+// merge inserts sorted values into q.samples, keeping it sorted by value,
+// then compresses. A new sample's delta is floor(invariant(r))-1 (never
+// negative): the rank-error budget already accrued at its insertion
+// point, per Cormode/Korkmaz-Muthukrishnan. A value past every existing
+// sample gets delta 0, since it may be the new exact maximum.
+func (q *quantileStream) merge(sorted []float64) {
+	var r float64
+	i := 0
+	for _, v := range sorted {
+		for ; i < len(q.samples); i++ {
+			if q.samples[i].value > v {
+				delta := math.Floor(q.invariant(r)) - 1
+				if delta < 0 {
+					delta = 0
+				}
+				q.samples = append(q.samples, quantileSample{})
+				copy(q.samples[i+1:], q.samples[i:])
+				q.samples[i] = quantileSample{value: v, width: 1, delta: delta}
+				goto inserted
+			}
+			r += q.samples[i].width
+		}
+		q.samples = append(q.samples, quantileSample{value: v, width: 1, delta: 0})
+	inserted:
+		i++
+		q.n++
+		r++
+	}
+	q.compress()
+}
+
+// compress merges samples into their higher neighbor from the top down
+// while the merged width+delta still satisfies the rank-error bound,
+// bounding the total number retained.
+func (q *quantileStream) compress() {
+	if len(q.samples) < 2 {
+		return
+	}
+	x := q.samples[len(q.samples)-1]
+	xi := len(q.samples) - 1
+	r := q.n - 1 - x.width
+
+	for i := len(q.samples) - 2; i >= 0; i-- {
+		c := q.samples[i]
+		if c.width+x.width+x.delta <= q.invariant(r) {
+			x.width += c.width
+			q.samples[xi] = x
+			q.samples = append(q.samples[:i], q.samples[i+1:]...)
+			xi--
+		} else {
+			x = c
+			xi = i
+		}
+		r -= c.width
+	}
+}
+
+// invariant returns the max rank error allowed at rank r (CKMS f(r,n)).
+func (q *quantileStream) invariant(r float64) float64 {
+	min := math.MaxFloat64
+	for _, t := range q.targets {
+		var f float64
+		if t.quantile*q.n <= r {
+			f = 2 * t.epsilon * r / t.quantile
+		} else {
+			f = 2 * t.epsilon * (q.n - r) / (1 - t.quantile)
+		}
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// Query returns the approximate value at quantile (0, 1].
+func (q *quantileStream) Query(quantile float64) float64 {
+	q.flush()
+	if len(q.samples) == 0 {
+		return 0
+	}
+	rank := math.Ceil(quantile * q.n)
+	rank += math.Ceil(q.invariant(rank) / 2)
+
+	p := q.samples[0]
+	var r float64
+	for _, c := range q.samples[1:] {
+		r += p.width
+		if r+c.width+c.delta > rank {
+			return p.value
+		}
+		p = c
+	}
+	return p.value
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestQuantileStreamAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := 200000
+	data := make([]float64, n)
+	for i := range data {
+		data[i] = rng.ExpFloat64()
+	}
+
+	q := newQuantileStream(defaultQuantileTargets)
+	for _, v := range data {
+		q.Insert(v)
+	}
+
+	sorted := append([]float64(nil), data...)
+	sort.Float64s(sorted)
+
+	// newQuantileStream guarantees the *rank* of a returned value is
+	// within epsilon of the target quantile, not a bound on its value
+	// directly; a relative-value check would fail near the low end of a
+	// skewed distribution like this one even when the rank guarantee
+	// holds.
+	for _, target := range defaultQuantileTargets {
+		got := q.Query(target.quantile)
+		rank := sort.SearchFloat64s(sorted, got)
+		actual := float64(rank) / float64(len(sorted))
+		if diff := math.Abs(actual - target.quantile); diff > target.epsilon {
+			t.Errorf("quantile %.4f: got %v, whose actual quantile is %.5f (diff %.5f > epsilon %.5f)",
+				target.quantile, got, actual, diff, target.epsilon)
+		}
+	}
+}
+
+// A correctly compressing stream retains a small, roughly constant
+// number of samples regardless of how many values were inserted.
+func TestQuantileStreamBoundedMemory(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	q := newQuantileStream(defaultQuantileTargets)
+	for i := 0; i < 200000; i++ {
+		q.Insert(rng.ExpFloat64())
+	}
+	q.flush()
+
+	if got := len(q.samples); got > 1000 {
+		t.Errorf("retained %d samples for 200000 inserts, want a small bounded count", got)
+	}
+}
@@ -15,10 +15,11 @@
 package control
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"math"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,39 +31,99 @@ type result struct {
 	errStr   string
 	duration time.Duration
 	happened time.Time
+
+	// status is the gRPC/etcd response code for this request (codes.OK
+	// on success), used to build the status code and error class
+	// distributions.
+	status int
 }
 
 type report struct {
-	avgTotal float64
-	fastest  float64
-	slowest  float64
-	average  float64
-	stddev   float64
-	rps      float64
+	rps   float64
+	total time.Duration
 
 	results chan result
-	total   time.Duration
+
+	// mu guards the fields below while finalize is still consuming
+	// results, so a concurrent live report can safely snapshot them.
+	mu sync.Mutex
 
 	errorDist map[string]int
 
-	// latencies in seconds
-	lats []float64
+	// statusCodeDist counts responses by status/response code.
+	statusCodeDist map[int]int
+	// classLatencies tracks latency percentiles per status class (see
+	// statusClass), so a slow 5xx/Unavailable class can be told apart
+	// from a fast-failing 4xx/InvalidArgument one.
+	classLatencies map[string]*classLatencyTracker
+
+	// stats accumulates count/min/max/mean/stddev online, in one pass.
+	stats *Stats
+	// hdr records latencies in an HDR-style logarithmic histogram,
+	// giving O(1)-per-sample percentiles and distributions without
+	// sorting or a fixed bucket layout.
+	hdr *latencyRecorder
 
 	sps *secondPoints
 
+	// metrics mirrors the report's fields as Prometheus collectors; nil
+	// unless cfg.MetricsAddr is set.
+	metrics *benchMetrics
+
+	// sinks fans each result out to the formats in cfg.ResultFormats
+	// (text/csv/ndjson/json), and produces the final report output once
+	// the run is over.
+	sinks *sinkRouter
+
 	cfg Config
 }
 
 func printReport(results chan result, cfg Config) <-chan struct{} {
 	return wrapReport(func() {
+		hdr, err := newDefaultLatencyRecorder(cfg)
+		if err != nil {
+			plog.Fatal(err)
+		}
+
 		r := &report{
-			results:   results,
-			errorDist: make(map[string]int),
-			sps:       newSecondPoints(),
-			cfg:       cfg,
+			results:        results,
+			errorDist:      make(map[string]int),
+			statusCodeDist: make(map[int]int),
+			classLatencies: make(map[string]*classLatencyTracker),
+			stats:          NewStats(),
+			hdr:            hdr,
+			sps:            newSecondPoints(),
+			cfg:            cfg,
+		}
+		if cfg.MetricsAddr != "" {
+			r.metrics = newBenchMetrics()
+			stopServing := r.metrics.serve(cfg.MetricsAddr)
+			defer func() {
+				if err := stopServing(context.Background()); err != nil {
+					plog.Warningf("metrics server on %s failed to shut down: %v", cfg.MetricsAddr, err)
+				}
+			}()
+			if !cfg.LiveReport {
+				stop := r.sampleRPSForMetrics()
+				defer stop()
+			}
 		}
+
+		sinks, err := newSinkRouter(cfg)
+		if err != nil {
+			plog.Fatal(err)
+		}
+		r.sinks = sinks
+
 		r.finalize()
-		r.print()
+
+		if r.metrics != nil && cfg.PushgatewayAddr != "" {
+			if err := r.metrics.pushToGateway(cfg.PushgatewayAddr); err != nil {
+				plog.Warningf("failed to push metrics to %s: %v", cfg.PushgatewayAddr, err)
+			}
+		}
+
+		r.sinks.Finish(r)
 	})
 }
 
@@ -78,40 +139,52 @@ func wrapReport(f func()) <-chan struct{} {
 func (r *report) finalize() {
 	plog.Printf("finalize has started")
 	st := time.Now()
+	stopLive := streamLiveReport(r)
+	defer stopLive()
 	for res := range r.results {
+		r.mu.Lock()
+		r.statusCodeDist[res.status]++
+		class := statusClass(res.status)
+		if r.classLatencies[class] == nil {
+			r.classLatencies[class] = &classLatencyTracker{}
+		}
+		r.classLatencies[class].Insert(res.duration.Seconds())
+		if r.metrics != nil {
+			r.metrics.Observe(res)
+		}
+
 		if res.errStr != "" {
 			r.errorDist[res.errStr]++
 		} else {
 			r.sps.Add(res.happened, res.duration)
-			r.lats = append(r.lats, res.duration.Seconds())
-			r.avgTotal += res.duration.Seconds()
+			r.stats.Add(res.duration.Seconds())
+			r.hdr.Record(res.duration)
 		}
-	}
-	r.total = time.Since(st)
+		r.mu.Unlock()
 
-	r.rps = float64(len(r.lats)) / r.total.Seconds()
-	r.average = r.avgTotal / float64(len(r.lats))
-	for i := range r.lats {
-		dev := r.lats[i] - r.average
-		r.stddev += dev * dev
+		r.sinks.Route(res)
 	}
-	r.stddev = math.Sqrt(r.stddev / float64(len(r.lats)))
+	r.total = time.Since(st)
+	r.rps = float64(r.stats.Count()) / r.total.Seconds()
 	plog.Printf("finalize has finished")
 }
 
 func (r *report) print() {
-	plog.Println("printing", len(r.lats), "results")
-	sort.Float64s(r.lats)
+	plog.Println("printing", r.stats.Count(), "results")
 
-	if len(r.lats) > 0 {
-		r.fastest = r.lats[0]
-		r.slowest = r.lats[len(r.lats)-1]
+	// statusCodeDist is incremented for every response, success or
+	// error, so it must not be gated on r.stats.Count() (successes
+	// only) — an all-error run (target down, auth rejecting everything,
+	// ...) is exactly when operators need status/error-class reporting
+	// most, and printStatusCodes already no-ops when there's nothing to
+	// report.
+	if r.stats.Count() > 0 {
 		fmt.Printf("\nSummary:\n")
 		fmt.Printf("  Total:\t%4.4f secs.\n", r.total.Seconds())
-		fmt.Printf("  Slowest:\t%4.4f secs.\n", r.slowest)
-		fmt.Printf("  Fastest:\t%4.4f secs.\n", r.fastest)
-		fmt.Printf("  Average:\t%4.4f secs.\n", r.average)
-		fmt.Printf("  Stddev:\t%4.4f secs.\n", r.stddev)
+		fmt.Printf("  Slowest:\t%4.4f secs.\n", r.stats.Max())
+		fmt.Printf("  Fastest:\t%4.4f secs.\n", r.stats.Min())
+		fmt.Printf("  Average:\t%4.4f secs.\n", r.stats.Mean())
+		fmt.Printf("  Stddev:\t%4.4f secs.\n", r.stats.Stddev())
 		fmt.Printf("  Requests/sec:\t%4.4f\n", r.rps)
 
 		fmt.Printf("\n")
@@ -121,6 +194,23 @@ func (r *report) print() {
 		fmt.Printf("\n")
 		r.printLatencies()
 		fmt.Printf("\n")
+	}
+
+	r.printStatusCodes()
+	fmt.Printf("\n")
+
+	// r.hdr only records successes too, but the interval log/JSON
+	// summary are written whenever there was any traffic at all (not
+	// gated on r.stats.Count()) so a run that failed outright still
+	// produces the diffable artifact downstream tooling expects,
+	// instead of the one run an operator would most want to compare
+	// against a healthy baseline silently having none.
+	if len(r.statusCodeDist) > 0 {
+		r.printHDRSummary()
+		fmt.Printf("\n")
+	}
+
+	if r.stats.Count() > 0 {
 		r.printSecondSample()
 		fmt.Printf("\n")
 	}
@@ -128,22 +218,13 @@ func (r *report) print() {
 	plog.Println("ERROR COUNT:", r.errorDist)
 }
 
-// Prints percentile latencies.
+// Prints percentile latencies, read from the HDR histogram.
 func (r *report) printLatencies() {
-	pctls := []int{10, 25, 50, 75, 90, 95, 99}
-	data := make([]float64, len(pctls))
-	j := 0
-	for i := 0; i < len(r.lats) && j < len(pctls); i++ {
-		current := i * 100 / len(r.lats)
-		if current >= pctls[j] {
-			data[j] = r.lats[i]
-			j++
-		}
-	}
+	pctls := []float64{10, 25, 50, 75, 90, 95, 99}
 	fmt.Printf("\nLatency distribution:\n")
-	for i := 0; i < len(pctls); i++ {
-		if data[i] > 0 {
-			fmt.Printf("  %v%% in %4.4f secs.\n", pctls[i], data[i])
+	for _, p := range pctls {
+		if v := r.hdr.Percentile(p); v > 0 {
+			fmt.Printf("  %v%% in %4.4f secs.\n", int(p), v.Seconds())
 		}
 	}
 }
@@ -161,75 +242,65 @@ func (r *report) printSecondSample() {
 	plog.Println("saved time series at", r.cfg.ResultPathTimeSeries)
 }
 
-// printLatencyDistribution prints latency distribution by 10ms.
+// printLatencyDistribution prints latency distribution by 10ms, read off
+// the HDR histogram's recorded bars instead of truncating every
+// observed latency into a map.
 func (r *report) printLatencyDistribution() {
-	plog.Printf("analyzing latency distribution of %d points", len(r.lats))
-	min := math.MaxFloat64
-	max := -100000.0
-	rm := make(map[float64]int)
-	for _, lt := range r.lats {
-		// convert second(float64) to millisecond
-		ms := lt * 1000
-
-		// truncate all digits below 10ms
-		// (e.g. 125.11ms becomes 120ms)
-		v := math.Trunc(ms/10) * 10
-		if _, ok := rm[v]; !ok {
-			rm[v] = 1
-		} else {
-			rm[v]++
-		}
-
-		if min > v {
-			min = v
-		}
-		if max < v {
-			max = v
-		}
+	bars := r.hdr.Distribution()
+	plog.Printf("analyzing latency distribution of %d bars", len(bars))
+	byMS := make(map[int64]int64)
+	for _, b := range bars {
+		ms := b.From / 1000 / 10 * 10
+		byMS[ms] += b.Count
 	}
-
-	cur := min
-	for cur != max {
-		v, ok := rm[cur]
-		if ok {
-			fmt.Printf("%dms: %d\n", int64(cur), v)
-		} else {
-			fmt.Printf("%dms: 0\n", int64(cur))
-		}
-		cur += 10
+	for ms := int64(0); ms <= r.hdr.hist.Max()/1000; ms += 10 {
+		fmt.Printf("%dms: %d\n", ms, byMS[ms])
 	}
 }
 
 func (r *report) printHistogram() {
-	bc := 10
-	buckets := make([]float64, bc+1)
-	counts := make([]int, bc+1)
-	bs := (r.slowest - r.fastest) / float64(bc)
-	for i := 0; i < bc; i++ {
-		buckets[i] = r.fastest + bs*float64(i)
-	}
-	buckets[bc] = r.slowest
-	var bi int
-	var max int
-	for i := 0; i < len(r.lats); {
-		if r.lats[i] <= buckets[bi] {
-			i++
-			counts[bi]++
-			if max < counts[bi] {
-				max = counts[bi]
-			}
-		} else if bi < len(buckets)-1 {
-			bi++
+	bars := r.hdr.Distribution()
+	var max int64
+	for _, b := range bars {
+		if b.Count > max {
+			max = b.Count
 		}
 	}
 	fmt.Printf("\nResponse time histogram:\n")
-	for i := 0; i < len(buckets); i++ {
-		// Normalize bar lengths.
-		var barLen int
+	for _, b := range bars {
+		if b.Count == 0 {
+			continue
+		}
+		var barLen int64
 		if max > 0 {
-			barLen = counts[i] * 40 / max
+			barLen = b.Count * 40 / max
+		}
+		fmt.Printf("  %4.3f [%v]\t|%v\n", float64(b.From)/1e6, b.Count, strings.Repeat(barChar, int(barLen)))
+	}
+}
+
+// printHDRSummary writes the HDR histogram out in the two
+// machine-readable forms downstream tooling can diff two runs with: a
+// dbtester-specific interval log (see WriteIntervalLog for why it isn't
+// the genuine HdrHistogram V2 encoding), and a JSON percentile summary.
+// Either path left unset in Config is skipped.
+func (r *report) printHDRSummary() {
+	if r.cfg.ResultPathHDRIntervalLog != "" {
+		var buf bytes.Buffer
+		if err := r.hdr.WriteIntervalLog(&buf, "latency", time.Now().Add(-r.total), r.total); err != nil {
+			plog.Warningf("failed to build HDR interval log: %v", err)
+		} else if err := toFile(buf.String(), r.cfg.ResultPathHDRIntervalLog); err != nil {
+			plog.Fatal(err)
+		}
+	}
+
+	if r.cfg.ResultPathHDRSummary != "" {
+		summary, err := r.hdr.JSONSummary()
+		if err != nil {
+			plog.Warningf("failed to build HDR JSON summary: %v", err)
+		} else if err := toFile(string(summary), r.cfg.ResultPathHDRSummary); err != nil {
+			plog.Fatal(err)
 		}
-		fmt.Printf("  %4.3f [%v]\t|%v\n", buckets[i], counts[i], strings.Repeat(barChar, barLen))
 	}
 }
 
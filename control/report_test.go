@@ -0,0 +1,146 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// runReport drives finalize and the text sink's print the way printReport
+// wires them together over results, and returns everything printed to
+// stdout.
+func runReport(t *testing.T, results []result) string {
+	t.Helper()
+
+	hdr, err := newDefaultLatencyRecorder(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks, err := newSinkRouter(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &report{
+		results:        make(chan result, len(results)),
+		errorDist:      make(map[string]int),
+		statusCodeDist: make(map[int]int),
+		classLatencies: make(map[string]*classLatencyTracker),
+		stats:          NewStats(),
+		hdr:            hdr,
+		sps:            newSecondPoints(),
+		sinks:          sinks,
+	}
+	for _, res := range results {
+		r.results <- res
+	}
+	close(r.results)
+
+	stdout := os.Stdout
+	rpipe, wpipe, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = wpipe
+
+	r.finalize()
+	r.sinks.Finish(r)
+
+	wpipe.Close()
+	os.Stdout = stdout
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, rpipe); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// A run where every request errors must still report, since that's
+// exactly when operators need status/error-class reporting and a
+// diffable HDR artifact most: r.stats never sees an errored result, so
+// gating these sections on r.stats.Count() silently printed nothing.
+func TestReportAllErrorsStillReportsStatus(t *testing.T) {
+	var results []result
+	for i := 0; i < 5; i++ {
+		results = append(results, result{
+			errStr:   "rpc error: context deadline exceeded",
+			duration: 50 * time.Millisecond,
+			happened: time.Now(),
+			status:   int(codes.DeadlineExceeded),
+		})
+	}
+
+	out := runReport(t, results)
+
+	if !strings.Contains(out, "Status code distribution:") {
+		t.Errorf("all-error run output missing status code distribution:\n%s", out)
+	}
+	if !strings.Contains(out, "DeadlineExceeded") {
+		t.Errorf("all-error run output missing the DeadlineExceeded class:\n%s", out)
+	}
+	if strings.Contains(out, "Summary:") {
+		t.Errorf("all-error run should have no Summary section (no successes to summarize):\n%s", out)
+	}
+}
+
+// A run with only successes must report the usual summary plus the
+// status code distribution (all OK).
+func TestReportAllSuccess(t *testing.T) {
+	var results []result
+	for i := 0; i < 5; i++ {
+		results = append(results, result{
+			duration: 10 * time.Millisecond,
+			happened: time.Now(),
+			status:   int(codes.OK),
+		})
+	}
+
+	out := runReport(t, results)
+
+	if !strings.Contains(out, "Summary:") {
+		t.Errorf("all-success run output missing Summary section:\n%s", out)
+	}
+	if !strings.Contains(out, "Status code distribution:") {
+		t.Errorf("all-success run output missing status code distribution:\n%s", out)
+	}
+}
+
+// A mixed run must report both the success summary and the error/status
+// class breakdown.
+func TestReportMixedSuccessAndError(t *testing.T) {
+	results := []result{
+		{duration: 10 * time.Millisecond, happened: time.Now(), status: int(codes.OK)},
+		{duration: 20 * time.Millisecond, happened: time.Now(), status: int(codes.OK)},
+		{errStr: "rpc error: unavailable", duration: 5 * time.Millisecond, happened: time.Now(), status: int(codes.Unavailable)},
+	}
+
+	out := runReport(t, results)
+
+	if !strings.Contains(out, "Summary:") {
+		t.Errorf("mixed run output missing Summary section:\n%s", out)
+	}
+	if !strings.Contains(out, "Status code distribution:") {
+		t.Errorf("mixed run output missing status code distribution:\n%s", out)
+	}
+	if !strings.Contains(out, "Unavailable") {
+		t.Errorf("mixed run output missing the Unavailable class:\n%s", out)
+	}
+}
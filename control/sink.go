@@ -0,0 +1,286 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// sinkChanBuffer is the per-sink channel buffer size.
+const sinkChanBuffer = 4096
+
+// ReportSink consumes completed results as they arrive and finishes
+// writing whatever it was building once the run is over.
+type ReportSink interface {
+	WriteResult(res result) error
+	Finish(r *report) error
+}
+
+// newReportSink builds the sink for format ("text", "csv", "ndjson", or
+// "json"), writing to path.
+func newReportSink(format, path string) (ReportSink, error) {
+	switch format {
+	case "text", "":
+		return &textSink{}, nil
+	case "csv":
+		return newCSVSink(path)
+	case "ndjson":
+		return newNDJSONSink(path)
+	case "json":
+		return &jsonSummarySink{path: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown result format %q", format)
+	}
+}
+
+// sinkRouter fans each result out to every enabled ReportSink through a
+// buffered channel and goroutine per sink.
+type sinkRouter struct {
+	sinks []ReportSink
+	chans []chan result
+	wg    sync.WaitGroup
+
+	// dropped counts results dropped per sink because its channel was
+	// full; warnOnce logs the first drop for that sink so a stalled sink
+	// is visible without spamming the log.
+	dropped  []int64
+	warnOnce []sync.Once
+}
+
+// newSinkRouter builds a sinkRouter for cfg.ResultFormats, defaulting to
+// the text report when none are configured.
+func newSinkRouter(cfg Config) (*sinkRouter, error) {
+	formats := cfg.ResultFormats
+	if len(formats) == 0 {
+		formats = []string{"text"}
+	}
+
+	sr := &sinkRouter{
+		dropped:  make([]int64, len(formats)),
+		warnOnce: make([]sync.Once, len(formats)),
+	}
+	for _, format := range formats {
+		sink, err := newReportSink(format, cfg.ResultPaths[format])
+		if err != nil {
+			return nil, err
+		}
+		ch := make(chan result, sinkChanBuffer)
+		sr.sinks = append(sr.sinks, sink)
+		sr.chans = append(sr.chans, ch)
+
+		sr.wg.Add(1)
+		go func(sink ReportSink, ch chan result) {
+			defer sr.wg.Done()
+			for res := range ch {
+				if err := sink.WriteResult(res); err != nil {
+					plog.Warningf("sink write failed: %v", err)
+				}
+			}
+		}(sink, ch)
+	}
+	return sr, nil
+}
+
+// Route hands res to every sink's channel independently, so a full
+// channel can't block Route or the other sinks. A sink stuck past its
+// buffer drops results instead: a blocking or unbounded send there would
+// let one stalled sink freeze the whole report.
+func (sr *sinkRouter) Route(res result) {
+	for i, ch := range sr.chans {
+		select {
+		case ch <- res:
+		default:
+			sr.dropped[i]++
+			sr.warnOnce[i].Do(func() {
+				plog.Warningf("sink %T can't keep up, dropping results for it", sr.sinks[i])
+			})
+		}
+	}
+}
+
+// Finish closes every sink's channel, waits for it to drain, then calls
+// Finish on each sink with the completed report.
+func (sr *sinkRouter) Finish(r *report) {
+	for _, ch := range sr.chans {
+		close(ch)
+	}
+	sr.wg.Wait()
+	for i, sink := range sr.sinks {
+		if sr.dropped[i] > 0 {
+			plog.Warningf("sink %T dropped %d results", sink, sr.dropped[i])
+		}
+		if err := sink.Finish(r); err != nil {
+			plog.Warningf("sink finish failed: %v", err)
+		}
+	}
+}
+
+// textSink reproduces the original human-readable report.
+type textSink struct{}
+
+func (s *textSink) WriteResult(result) error { return nil }
+
+func (s *textSink) Finish(r *report) error {
+	r.print()
+	return nil
+}
+
+// csvSink writes one row per completed request: happened, duration_ns,
+// err, status.
+type csvSink struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func newCSVSink(path string) (*csvSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"happened", "duration_ns", "err", "status"}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &csvSink{f: f, w: w}, nil
+}
+
+func (s *csvSink) WriteResult(res result) error {
+	return s.w.Write([]string{
+		res.happened.Format("2006-01-02T15:04:05.000000000Z07:00"),
+		strconv.FormatInt(res.duration.Nanoseconds(), 10),
+		res.errStr,
+		strconv.Itoa(res.status),
+	})
+}
+
+func (s *csvSink) Finish(*report) error {
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// ndjsonRow is one line written by ndjsonSink.
+type ndjsonRow struct {
+	Happened   string `json:"happened"`
+	DurationNs int64  `json:"duration_ns"`
+	Err        string `json:"err,omitempty"`
+	Status     int    `json:"status"`
+}
+
+// ndjsonSink streams the same fields as csvSink, one JSON object per line.
+type ndjsonSink struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newNDJSONSink(path string) (*ndjsonSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ndjsonSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *ndjsonSink) WriteResult(res result) error {
+	return s.enc.Encode(ndjsonRow{
+		Happened:   res.happened.Format("2006-01-02T15:04:05.000000000Z07:00"),
+		DurationNs: res.duration.Nanoseconds(),
+		Err:        res.errStr,
+		Status:     res.status,
+	})
+}
+
+func (s *ndjsonSink) Finish(*report) error {
+	return s.f.Close()
+}
+
+// jsonSummary is the single JSON blob jsonSummarySink writes: aggregate
+// stats, percentiles, histogram, per-second series, and error/status
+// distributions.
+type jsonSummary struct {
+	Total        float64            `json:"total_secs"`
+	RPS          float64            `json:"requests_per_second"`
+	Count        int64              `json:"count"`
+	Mean         float64            `json:"mean_secs"`
+	Stddev       float64            `json:"stddev_secs"`
+	Min          float64            `json:"min_secs"`
+	Max          float64            `json:"max_secs"`
+	Percentiles  map[string]float64 `json:"percentiles_secs"`
+	Histogram    []histogramBarJSON `json:"histogram"`
+	SecondPoints string             `json:"second_points"`
+	ErrorDist    map[string]int     `json:"error_distribution"`
+	StatusCodes  map[int]int        `json:"status_code_distribution"`
+}
+
+// histogramBarJSON is one bar of the HDR distribution, in seconds.
+type histogramBarJSON struct {
+	From  float64 `json:"from_secs"`
+	To    float64 `json:"to_secs"`
+	Count int64   `json:"count"`
+}
+
+// jsonSummarySink assembles the aggregate summary once the run is over.
+type jsonSummarySink struct {
+	path string
+}
+
+func (s *jsonSummarySink) WriteResult(result) error { return nil }
+
+func (s *jsonSummarySink) Finish(r *report) error {
+	pctls := []float64{10, 25, 50, 75, 90, 95, 99, 99.9}
+	percentiles := make(map[string]float64, len(pctls))
+	for _, p := range pctls {
+		percentiles[strconv.FormatFloat(p, 'f', -1, 64)] = r.hdr.Percentile(p).Seconds()
+	}
+
+	bars := r.hdr.Distribution()
+	hist := make([]histogramBarJSON, 0, len(bars))
+	for _, b := range bars {
+		if b.Count == 0 {
+			continue
+		}
+		hist = append(hist, histogramBarJSON{From: float64(b.From) / 1e6, To: float64(b.To) / 1e6, Count: b.Count})
+	}
+
+	summary := jsonSummary{
+		Total:        r.total.Seconds(),
+		RPS:          r.rps,
+		Count:        r.stats.Count(),
+		Mean:         r.stats.Mean(),
+		Stddev:       r.stats.Stddev(),
+		Min:          r.stats.Min(),
+		Max:          r.stats.Max(),
+		Percentiles:  percentiles,
+		Histogram:    hist,
+		SecondPoints: r.sps.getTimeSeries().String(),
+		ErrorDist:    r.errorDist,
+		StatusCodes:  r.statusCodeDist,
+	}
+
+	b, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return toFile(string(b), s.path)
+}
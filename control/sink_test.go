@@ -0,0 +1,83 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// noopSink is a ReportSink that does nothing, for routing tests that
+// don't care about a sink's actual output.
+type noopSink struct{}
+
+func (noopSink) WriteResult(result) error { return nil }
+func (noopSink) Finish(*report) error     { return nil }
+
+// A stalled sink's channel must not stop Route from reaching healthy
+// sinks or returning to its caller, and must not block Finish either.
+func TestSinkRouterRouteDoesNotBlockOnStalledSink(t *testing.T) {
+	stalled := make(chan result) // unbuffered, nothing ever reads it
+	healthy := make(chan result, 20)
+	sr := &sinkRouter{
+		sinks:    []ReportSink{noopSink{}, noopSink{}},
+		chans:    []chan result{stalled, healthy},
+		dropped:  make([]int64, 2),
+		warnOnce: make([]sync.Once, 2),
+	}
+
+	var got []result
+	drained := make(chan struct{})
+	go func() {
+		for res := range healthy {
+			got = append(got, res)
+		}
+		close(drained)
+	}()
+
+	routed := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			sr.Route(result{status: i})
+		}
+		close(routed)
+	}()
+
+	select {
+	case <-routed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Route blocked on a stalled sink channel")
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		sr.Finish(&report{})
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Finish blocked on the stalled sink's channel")
+	}
+	<-drained
+
+	if len(got) != 20 {
+		t.Fatalf("healthy sink got %d of 20 routed results", len(got))
+	}
+	if sr.dropped[0] == 0 {
+		t.Errorf("expected Route to record drops for the stalled sink")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import "math"
+
+// Stats accumulates count/sum/min/max and a running mean/variance in a
+// single pass, without buffering every observed value.
+type Stats struct {
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+
+	mean float64
+	m2   float64
+}
+
+// NewStats returns an empty Stats accumulator.
+func NewStats() *Stats {
+	return &Stats{
+		min: math.MaxFloat64,
+		max: -math.MaxFloat64,
+	}
+}
+
+// Add folds v into the running count, sum, min/max, mean and variance.
+func (s *Stats) Add(v float64) {
+	s.count++
+	s.sum += v
+	if v < s.min {
+		s.min = v
+	}
+	if v > s.max {
+		s.max = v
+	}
+
+	// Welford's method.
+	delta := v - s.mean
+	s.mean += delta / float64(s.count)
+	delta2 := v - s.mean
+	s.m2 += delta * delta2
+}
+
+// Count returns the number of observations added so far.
+func (s *Stats) Count() int64 { return s.count }
+
+// Sum returns the running sum of all observations.
+func (s *Stats) Sum() float64 { return s.sum }
+
+// Min returns the smallest observation, or 0 if none were added.
+func (s *Stats) Min() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.min
+}
+
+// Max returns the largest observation, or 0 if none were added.
+func (s *Stats) Max() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.max
+}
+
+// Mean returns the running arithmetic mean.
+func (s *Stats) Mean() float64 { return s.mean }
+
+// Variance returns the running population variance.
+func (s *Stats) Variance() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.m2 / float64(s.count)
+}
+
+// Stddev returns the running population standard deviation.
+func (s *Stats) Stddev() float64 {
+	return math.Sqrt(s.Variance())
+}
@@ -0,0 +1,51 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	s := NewStats()
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		s.Add(v)
+	}
+
+	if s.Count() != 8 {
+		t.Errorf("Count() = %d, want 8", s.Count())
+	}
+	if s.Min() != 2 {
+		t.Errorf("Min() = %v, want 2", s.Min())
+	}
+	if s.Max() != 9 {
+		t.Errorf("Max() = %v, want 9", s.Max())
+	}
+	if s.Mean() != 5 {
+		t.Errorf("Mean() = %v, want 5", s.Mean())
+	}
+	if want := 2.0; math.Abs(s.Stddev()-want) > 1e-9 {
+		t.Errorf("Stddev() = %v, want %v", s.Stddev(), want)
+	}
+}
+
+func TestStatsEmpty(t *testing.T) {
+	s := NewStats()
+	if s.Min() != 0 || s.Max() != 0 || s.Mean() != 0 || s.Stddev() != 0 {
+		t.Errorf("empty Stats should report zero values, got min=%v max=%v mean=%v stddev=%v",
+			s.Min(), s.Max(), s.Mean(), s.Stddev())
+	}
+}
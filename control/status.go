@@ -0,0 +1,105 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+)
+
+// statusClass groups a gRPC status code into its class name.
+func statusClass(status int) string {
+	return codes.Code(status).String()
+}
+
+// exactSampleThreshold is the sample count up to which classLatencyTracker
+// keeps exact values instead of switching to quantileStream. quantileStream
+// is tuned for high-volume streams; at low counts it can answer a query
+// with a value nowhere near the true percentile.
+const exactSampleThreshold = 1000
+
+// classLatencyTracker reports exact percentiles for a status class until
+// its sample count passes exactSampleThreshold, then hands off to a
+// quantileStream so classes like "OK" don't retain every sample.
+type classLatencyTracker struct {
+	exact  []float64
+	stream *quantileStream
+}
+
+// Insert adds v, converting to streaming mode once exactSampleThreshold
+// is exceeded.
+func (c *classLatencyTracker) Insert(v float64) {
+	if c.stream != nil {
+		c.stream.Insert(v)
+		return
+	}
+	c.exact = append(c.exact, v)
+	if len(c.exact) > exactSampleThreshold {
+		c.stream = newQuantileStream(defaultQuantileTargets)
+		for _, e := range c.exact {
+			c.stream.Insert(e)
+		}
+		c.exact = nil
+	}
+}
+
+// Query returns the value at quantile (0, 1].
+func (c *classLatencyTracker) Query(quantile float64) float64 {
+	if c.stream != nil {
+		return c.stream.Query(quantile)
+	}
+	if len(c.exact) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), c.exact...)
+	sort.Float64s(sorted)
+	i := int(quantile * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// printStatusCodes prints response counts and per-class latency
+// percentiles.
+func (r *report) printStatusCodes() {
+	if len(r.statusCodeDist) == 0 {
+		return
+	}
+
+	fmt.Printf("\nStatus code distribution:\n")
+	codesSeen := make([]int, 0, len(r.statusCodeDist))
+	for code := range r.statusCodeDist {
+		codesSeen = append(codesSeen, code)
+	}
+	sort.Ints(codesSeen)
+	for _, code := range codesSeen {
+		fmt.Printf("  [%s]\t%d responses\n", statusClass(code), r.statusCodeDist[code])
+	}
+
+	fmt.Printf("\nLatency distribution by status class:\n")
+	classes := make([]string, 0, len(r.classLatencies))
+	for class := range r.classLatencies {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		q := r.classLatencies[class]
+		fmt.Printf("  %s:\tp50 %4.4f secs.\tp90 %4.4f secs.\tp99 %4.4f secs.\n",
+			class, q.Query(0.50), q.Query(0.90), q.Query(0.99))
+	}
+}
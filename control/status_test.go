@@ -0,0 +1,40 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import "testing"
+
+func TestClassLatencyTrackerExact(t *testing.T) {
+	c := &classLatencyTracker{}
+	for _, v := range []float64{5, 10, 15} {
+		c.Insert(v)
+	}
+	if got := c.Query(0.50); got != 10 {
+		t.Errorf("Query(0.50) = %v, want 10", got)
+	}
+}
+
+func TestClassLatencyTrackerConvertsToStream(t *testing.T) {
+	c := &classLatencyTracker{}
+	for i := 0; i < exactSampleThreshold+1; i++ {
+		c.Insert(float64(i))
+	}
+	if c.stream == nil {
+		t.Fatal("expected tracker to convert to streaming mode")
+	}
+	if c.exact != nil {
+		t.Errorf("expected exact samples to be released, got %d", len(c.exact))
+	}
+}
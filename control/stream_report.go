@@ -0,0 +1,134 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// defaultLiveReportInterval is used when Config.LiveReportInterval is unset.
+const defaultLiveReportInterval = time.Second
+
+// ansiClearScreen homes the cursor and clears the screen below it.
+const ansiClearScreen = "\x1b[H\x1b[J"
+
+// liveReporter periodically snapshots a report while finalize is still
+// filling it in.
+type liveReporter struct {
+	r *report
+
+	start     time.Time
+	lastCount int64
+	lastTime  time.Time
+}
+
+// streamLiveReport starts the dashboard goroutine when cfg.LiveReport is
+// set and returns a func to stop it; a no-op otherwise.
+func streamLiveReport(r *report) (stop func()) {
+	if !r.cfg.LiveReport {
+		return func() {}
+	}
+
+	interval := r.cfg.LiveReportInterval
+	if interval <= 0 {
+		interval = defaultLiveReportInterval
+	}
+
+	now := time.Now()
+	lr := &liveReporter{r: r, start: now, lastTime: now}
+	donec := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				lr.render()
+			case <-donec:
+				return
+			}
+		}
+	}()
+
+	return func() { close(donec) }
+}
+
+// render prints one frame of the live dashboard.
+func (lr *liveReporter) render() {
+	r := lr.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	sinceLast := now.Sub(lr.lastTime).Seconds()
+	count := r.stats.Count()
+	rollingRPS := 0.0
+	if sinceLast > 0 {
+		rollingRPS = float64(count-lr.lastCount) / sinceLast
+	}
+	lr.lastCount = count
+	lr.lastTime = now
+	if r.metrics != nil {
+		r.metrics.SetRPS(rollingRPS)
+	}
+
+	var buf strings.Builder
+	if isTerminal(os.Stdout) {
+		buf.WriteString(ansiClearScreen)
+	}
+	fmt.Fprintf(&buf, "Live report (%s elapsed)\n", now.Sub(lr.start).Round(time.Second))
+	fmt.Fprintf(&buf, "  Requests:\t%d\n", count)
+	fmt.Fprintf(&buf, "  RPS (rolling):\t%4.2f\n", rollingRPS)
+	fmt.Fprintf(&buf, "  Mean:\t%4.4f secs.\tStddev:\t%4.4f secs.\n", r.stats.Mean(), r.stats.Stddev())
+	fmt.Fprintf(&buf, "  p50:\t%4.4f secs.\tp90:\t%4.4f secs.\tp99:\t%4.4f secs.\n",
+		r.hdr.Percentile(50).Seconds(), r.hdr.Percentile(90).Seconds(), r.hdr.Percentile(99).Seconds())
+	if len(r.errorDist) > 0 {
+		fmt.Fprintf(&buf, "  Errors:\t%v\n", r.errorDist)
+	}
+	if sample, sec, ok := latestSecondSample(r.sps); ok {
+		fmt.Fprintf(&buf, "  Latest second (t=%d):\t%v\n", sec, sample)
+	}
+
+	fmt.Print(buf.String())
+}
+
+// latestSecondSample returns the most recent per-second bucket recorded
+// in sp, keyed by its unix-second timestamp, for the live dashboard's
+// per-second view.
+func latestSecondSample(sp *secondPoints) (sample time.Duration, sec int64, ok bool) {
+	if sp == nil {
+		return 0, 0, false
+	}
+	latest := int64(-1)
+	for t, d := range sp.tm {
+		if t > latest {
+			latest, sample = t, d
+		}
+	}
+	if latest < 0 {
+		return 0, 0, false
+	}
+	return sample, latest, true
+}
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool {
+	return terminal.IsTerminal(int(f.Fd()))
+}
@@ -0,0 +1,101 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestReport builds a report with just enough state initialized for
+// finalize/streamLiveReport/metrics code paths to run without a real
+// benchmark in progress.
+func newTestReport(t *testing.T, cfg Config) *report {
+	hdr, err := newDefaultLatencyRecorder(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &report{
+		errorDist:      make(map[string]int),
+		statusCodeDist: make(map[int]int),
+		classLatencies: make(map[string]*classLatencyTracker),
+		stats:          NewStats(),
+		hdr:            hdr,
+		cfg:            cfg,
+	}
+}
+
+// Disabled LiveReport must not start a rendering goroutine; stop must be
+// safe to call regardless.
+func TestStreamLiveReportDisabled(t *testing.T) {
+	r := newTestReport(t, Config{})
+	r.metrics = newBenchMetrics()
+
+	stop := streamLiveReport(r)
+	stop()
+
+	if got := testutil.ToFloat64(r.metrics.rps); got != 0 {
+		t.Errorf("rps gauge = %v, want 0 (no render should have run)", got)
+	}
+}
+
+// latestSecondSample must pick the highest key, not just any entry, and
+// report ok=false when there's nothing recorded yet.
+func TestLatestSecondSample(t *testing.T) {
+	if _, _, ok := latestSecondSample(nil); ok {
+		t.Fatal("nil secondPoints should report ok=false")
+	}
+
+	sp := newSecondPoints()
+	sp.tm[100] = 10 * time.Millisecond
+	sp.tm[102] = 30 * time.Millisecond
+	sp.tm[101] = 20 * time.Millisecond
+
+	sample, sec, ok := latestSecondSample(sp)
+	if !ok {
+		t.Fatal("expected ok=true with entries present")
+	}
+	if sec != 102 {
+		t.Errorf("sec = %d, want 102 (the highest key)", sec)
+	}
+	if sample != 30*time.Millisecond {
+		t.Errorf("sample = %v, want 30ms", sample)
+	}
+}
+
+// Enabled LiveReport must render on its ticker, observable here via the
+// RPS gauge it feeds on each frame.
+func TestStreamLiveReportEnabledRenders(t *testing.T) {
+	r := newTestReport(t, Config{LiveReport: true, LiveReportInterval: 5 * time.Millisecond})
+	r.metrics = newBenchMetrics()
+	r.stats.Add(0.1)
+
+	stop := streamLiveReport(r)
+	defer stop()
+
+	deadline := time.After(time.Second)
+	for {
+		if testutil.ToFloat64(r.metrics.rps) != 0 {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatal("streamLiveReport never rendered a frame within 1s")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}